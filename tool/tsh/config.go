@@ -17,17 +17,22 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"text/template"
 
 	"github.com/gravitational/teleport/api/constants"
 	"github.com/gravitational/teleport/api/profile"
+	"github.com/gravitational/teleport/api/types"
 	"github.com/gravitational/teleport/api/utils/keypaths"
+	"github.com/gravitational/teleport/lib/client"
+	"github.com/gravitational/teleport/lib/utils"
 	"github.com/gravitational/trace"
 )
 
@@ -35,15 +40,58 @@ const sshConfigTemplate = `
 # Common flags for all {{ .ClusterName }} hosts
 Host *.{{ .ClusterName }} {{ .ProxyHost }}
     UserKnownHostsFile "{{ .KnownHostsPath }}"
+{{- if eq .IdentityFormat "agent" }}
+    IdentityAgent {{ .AgentSocketPath }}
+{{- else if eq .IdentityFormat "pkcs11" }}
+    PKCS11Provider "{{ .PKCS11ModulePath }}"
+    CertificateFile "{{ .CertificateFilePath }}"
+{{- else }}
     IdentityFile "{{ .IdentityFilePath }}"
     CertificateFile "{{ .CertificateFilePath }}"
+{{- end }}
+{{- if .ProxyJump }}
+
+# Flags for the {{ .ClusterName }} proxy only
+Host {{ .ProxyHost }}
+    ProxyJump {{ .ProxyJump }}
+{{- end }}
 
 # Flags for all {{ .ClusterName }} hosts except the proxy
 Host *.{{ .ClusterName }} !{{ .ProxyHost }}
     Port 3022
-    ProxyCommand {{ .TSHPath }} config-proxy --proxy={{ .ProxyHost }}:{{ .ProxyPort }} %h:%p "{{ .ClusterName }}"
+    ProxyCommand {{ .TSHPath }} config-proxy --proxy={{ .ProxyHost }}:{{ .ProxyPort }}{{ if .ProxyJump }} --jumphost={{ .ProxyJump }}{{ end }} %h:%p "{{ .ClusterName }}"
 `
 
+// Supported values for the `tsh config --identity-format` flag, controlling
+// how the generated config references the user's Teleport-issued SSH
+// identity.
+const (
+	// identityFormatFile points IdentityFile/CertificateFile at the on-disk
+	// key and certificate written by `tsh login`. This is the default and
+	// matches the previous, only, behavior.
+	identityFormatFile = "file"
+	// identityFormatAgent references a running SSH agent (e.g. a tsh-managed
+	// agent, or the system ssh-agent holding a Teleport-issued key) via
+	// IdentityAgent, instead of reading key material from disk.
+	identityFormatAgent = "agent"
+	// identityFormatPKCS11 references a PKCS#11 provider module (e.g. a
+	// YubiKey's PIV applet) so the private key never leaves the hardware
+	// token.
+	identityFormatPKCS11 = "pkcs11"
+)
+
+// configFormatCertAuthority is the value of the `tsh config --format` flag
+// that switches `tsh config` from the default per-host UserKnownHostsFile
+// (populated via TOFU as hosts are visited) to a CA-signed known_hosts file
+// containing "@cert-authority" entries for the cluster's SSH host CAs. This
+// avoids TOFU prompts for nodes added after the config was generated.
+const configFormatCertAuthority = "cert-authority"
+
+// knownHostsCAFilename is the name of the generated known_hosts file
+// containing "@cert-authority" entries, written alongside the other
+// Teleport-managed files under the profile's keys directory.
+const knownHostsCAFilename = "known_hosts_ca"
+
 type hostConfigParameters struct {
 	ClusterName         string
 	KnownHostsPath      string
@@ -52,6 +100,83 @@ type hostConfigParameters struct {
 	ProxyHost           string
 	ProxyPort           string
 	TSHPath             string
+	// ProxyJump, when non-empty, is a pre-formatted OpenSSH ProxyJump value
+	// (e.g. "user@bastion1:22,user@bastion2:22") chaining through the hops
+	// required to reach ProxyHost.
+	ProxyJump string
+	// IdentityFormat selects how the generated config references the user's
+	// identity: identityFormatFile, identityFormatAgent or
+	// identityFormatPKCS11.
+	IdentityFormat string
+	// AgentSocketPath is the IdentityAgent socket path used when
+	// IdentityFormat is identityFormatAgent.
+	AgentSocketPath string
+	// PKCS11ModulePath is the PKCS#11 provider module path used when
+	// IdentityFormat is identityFormatPKCS11.
+	PKCS11ModulePath string
+}
+
+// formatProxyJump renders a chain of JumpHosts as a single OpenSSH
+// ProxyJump value, e.g. "alice@bastion1:22,alice@bastion2:22". OpenSSH
+// treats ProxyJump as a comma-separated list of hops to traverse in order.
+func formatProxyJump(jumpHosts []utils.JumpHost) string {
+	hops := make([]string, 0, len(jumpHosts))
+	for _, jumpHost := range jumpHosts {
+		if jumpHost.Username != "" {
+			hops = append(hops, fmt.Sprintf("%s@%s", jumpHost.Username, jumpHost.Addr.Addr))
+		} else {
+			hops = append(hops, jumpHost.Addr.Addr)
+		}
+	}
+
+	return strings.Join(hops, ",")
+}
+
+// fetchCertAuthorityLines fetches clusterName's SSH host CA public keys from
+// the proxy and renders them as OpenSSH "@cert-authority" known_hosts lines,
+// so that nodes in the cluster are trusted by signature rather than by TOFU.
+// extraHostPatterns are appended to the generated line's host pattern list
+// (e.g. the proxy's own hostname, which doesn't necessarily match
+// "*.<clusterName>").
+func fetchCertAuthorityLines(ctx context.Context, proxyClient *client.ProxyClient, clusterName string, extraHostPatterns ...string) ([]string, error) {
+	clt, err := proxyClient.ConnectToCluster(ctx, clusterName)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	defer clt.Close()
+
+	cas, err := clt.GetCertAuthorities(ctx, types.HostCA, false)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	lines := formatCertAuthorityLines(clusterName, cas, extraHostPatterns...)
+	if len(lines) == 0 {
+		return nil, trace.NotFound("no SSH host certificate authority found for cluster %q", clusterName)
+	}
+
+	return lines, nil
+}
+
+// formatCertAuthorityLines renders clusterName's SSH host CA checking keys
+// (filtered out of cas, which may also contain CAs belonging to other
+// clusters trusted by the auth server) as OpenSSH "@cert-authority"
+// known_hosts lines matching "*.<clusterName>" plus any extraHostPatterns
+// (e.g. the proxy's own hostname).
+func formatCertAuthorityLines(clusterName string, cas []types.CertAuthority, extraHostPatterns ...string) []string {
+	hostPatterns := strings.Join(append([]string{"*." + clusterName}, extraHostPatterns...), ",")
+
+	var lines []string
+	for _, ca := range cas {
+		if ca.GetType() != types.HostCA || ca.GetClusterName() != clusterName {
+			continue
+		}
+		for _, checkingKey := range ca.GetCheckingKeys() {
+			lines = append(lines, fmt.Sprintf("@cert-authority %s %s", hostPatterns, strings.TrimSpace(string(checkingKey))))
+		}
+	}
+
+	return lines
 }
 
 // getSSHPath returns a sane default `ssh` path for the current platform.
@@ -63,6 +188,34 @@ func getDefaultSSHPath() string {
 	return "/usr/bin/ssh"
 }
 
+// getDefaultPKCS11ModulePath returns a sane default path to a PKCS#11
+// provider module (e.g. a YubiKey PIV applet) for the current platform,
+// used as a fallback when --pkcs11-module-path isn't given. Other hardware
+// tokens (Nitrokey, SoloKey, etc.) require passing --pkcs11-module-path
+// explicitly.
+func getDefaultPKCS11ModulePath() (string, error) {
+	switch runtime.GOOS {
+	case constants.LinuxOS:
+		return "/usr/lib/x86_64-linux-gnu/libykcs11.so", nil
+	case constants.DarwinOS:
+		return "/usr/local/lib/libykcs11.dylib", nil
+	default:
+		return "", trace.BadParameter("--identity-format=pkcs11 is not supported on %s", runtime.GOOS)
+	}
+}
+
+// resolvePKCS11ModulePath returns override if set, otherwise falls back to
+// getDefaultPKCS11ModulePath, so that hardware tokens other than a YubiKey
+// (Nitrokey, SoloKey, etc.) can be used with --identity-format=pkcs11 by
+// passing --pkcs11-module-path explicitly.
+func resolvePKCS11ModulePath(override string) (string, error) {
+	if override != "" {
+		return override, nil
+	}
+
+	return getDefaultPKCS11ModulePath()
+}
+
 // writeSSHConfig generates an OpenSSH config block from the `sshConfigTemplate`
 // template string.
 func writeSSHConfig(sb *strings.Builder, params hostConfigParameters) error {
@@ -86,13 +239,58 @@ func onConfig(cf *CLIConf) error {
 		return trace.Wrap(err)
 	}
 
-	// Note: TeleportClient.connectToProxy() overrides the proxy address when
-	// JumpHosts are in use, which this does not currently implement.
+	// cf.JumpHost mirrors the `-J`/`--jumphost` syntax accepted elsewhere in
+	// tsh (e.g. `tsh ssh -J bastion`). When set here it overrides whatever
+	// JumpHosts makeClient already derived for tc, so `tsh config -J ...`
+	// can be used even when the ambient tsh invocation has none configured.
+	jumpHosts := tc.JumpHosts
+	if cf.JumpHost != "" {
+		jumpHosts, err = client.ParseProxyJump(cf.JumpHost)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+	}
+
+	// TeleportClient.connectToProxy() reaches SSHProxyAddr via the JumpHosts
+	// chain rather than directly when JumpHosts are in use; the generated
+	// config mirrors that below with a ProxyJump directive instead of
+	// overriding the proxy address itself.
 	proxyHost, proxyPort, err := net.SplitHostPort(tc.Config.SSHProxyAddr)
 	if err != nil {
 		return trace.Wrap(err)
 	}
 
+	proxyJump := formatProxyJump(jumpHosts)
+
+	identityFormat := cf.IdentityFormat
+	if identityFormat == "" {
+		identityFormat = identityFormatFile
+	}
+
+	var agentSocketPath, pkcs11ModulePath string
+	switch identityFormat {
+	case identityFormatFile:
+	case identityFormatAgent:
+		// ssh_config(5) does not expand a bare "$VAR" for IdentityAgent; the
+		// documented special case for "use the agent from the environment"
+		// is the literal string SSH_AUTH_SOCK (no leading "$").
+		agentSocketPath = "SSH_AUTH_SOCK"
+	case identityFormatPKCS11:
+		pkcs11ModulePath, err = resolvePKCS11ModulePath(cf.PKCS11ModulePath)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+	default:
+		return trace.BadParameter("invalid --identity-format %q, must be one of: %s, %s, %s",
+			identityFormat, identityFormatFile, identityFormatAgent, identityFormatPKCS11)
+	}
+
+	switch cf.Format {
+	case "", configFormatCertAuthority:
+	default:
+		return trace.BadParameter("invalid --format %q, must be one of: %s", cf.Format, configFormatCertAuthority)
+	}
+
 	// Note: We explicitly opt not to use RetryWithRelogin here as it will write
 	// its prompt to stdout. If the user pipes this command's output, the
 	// destination (possibly their ssh config file) may get polluted with
@@ -114,6 +312,26 @@ func onConfig(cf *CLIConf) error {
 	knownHostsPath := keypaths.KnownHostsPath(keysDir)
 	identityFilePath := keypaths.UserKeyPath(keysDir, proxyHost, tc.Config.Username)
 
+	if cf.Format == configFormatCertAuthority {
+		caLines, err := fetchCertAuthorityLines(cf.Context, proxyClient, rootClusterName, proxyHost)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		for _, leafCluster := range leafClusters {
+			leafLines, err := fetchCertAuthorityLines(cf.Context, proxyClient, leafCluster.GetName())
+			if err != nil {
+				return trace.Wrap(err)
+			}
+			caLines = append(caLines, leafLines...)
+		}
+
+		caKnownHostsPath := filepath.Join(keysDir, knownHostsCAFilename)
+		if err := os.WriteFile(caKnownHostsPath, []byte(strings.Join(caLines, "\n")+"\n"), 0600); err != nil {
+			return trace.Wrap(err, "writing cert authority known hosts file")
+		}
+		knownHostsPath = caKnownHostsPath
+	}
+
 	var sb strings.Builder
 
 	// Start with a newline in case an existing config file does not end with
@@ -129,6 +347,10 @@ func onConfig(cf *CLIConf) error {
 		ProxyHost:           proxyHost,
 		ProxyPort:           proxyPort,
 		TSHPath:             cf.executablePath,
+		ProxyJump:           proxyJump,
+		IdentityFormat:      identityFormat,
+		AgentSocketPath:     agentSocketPath,
+		PKCS11ModulePath:    pkcs11ModulePath,
 	})
 	if err != nil {
 		return trace.Wrap(err)
@@ -143,6 +365,10 @@ func onConfig(cf *CLIConf) error {
 			ProxyHost:           proxyHost,
 			ProxyPort:           proxyPort,
 			TSHPath:             cf.executablePath,
+			ProxyJump:           proxyJump,
+			IdentityFormat:      identityFormat,
+			AgentSocketPath:     agentSocketPath,
+			PKCS11ModulePath:    pkcs11ModulePath,
 		})
 		if err != nil {
 			return trace.Wrap(err)
@@ -169,12 +395,20 @@ func onConfigProxy(cf *CLIConf) error {
 	targetHost = strings.TrimSuffix(targetHost, "."+proxyHost)
 	targetHost = strings.TrimSuffix(targetHost, "."+cf.SiteName)
 
-	args := []string{
+	var args []string
+	// cf.JumpHost is populated from the generated config's --jumphost=...
+	// argument (see the ProxyCommand line in sshConfigTemplate) so that the
+	// real per-node path, not just the proxy's own Host block, also reaches
+	// the proxy through the configured bastion chain.
+	if cf.JumpHost != "" {
+		args = append(args, "-J", cf.JumpHost)
+	}
+	args = append(args,
 		"-p",
 		proxyPort,
 		proxyHost,
 		"-s",
-	}
+	)
 
 	args = append(args, fmt.Sprintf("proxy:%s:%s@%s", targetHost, targetPort, cf.SiteName))
 
@@ -0,0 +1,241 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gravitational/teleport/api/types"
+	"github.com/gravitational/teleport/lib/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatProxyJump(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		jumpHosts []utils.JumpHost
+		expected  string
+	}{
+		{
+			name:      "no jump hosts",
+			jumpHosts: nil,
+			expected:  "",
+		},
+		{
+			name: "single hop without username",
+			jumpHosts: []utils.JumpHost{
+				{Addr: utils.NetAddr{Addr: "bastion:22"}},
+			},
+			expected: "bastion:22",
+		},
+		{
+			name: "single hop with username",
+			jumpHosts: []utils.JumpHost{
+				{Username: "alice", Addr: utils.NetAddr{Addr: "bastion:22"}},
+			},
+			expected: "alice@bastion:22",
+		},
+		{
+			name: "multiple hops chained in order",
+			jumpHosts: []utils.JumpHost{
+				{Username: "alice", Addr: utils.NetAddr{Addr: "bastion1:22"}},
+				{Addr: utils.NetAddr{Addr: "bastion2:2222"}},
+				{Username: "bob", Addr: utils.NetAddr{Addr: "bastion3:22"}},
+			},
+			expected: "alice@bastion1:22,bastion2:2222,bob@bastion3:22",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, formatProxyJump(tt.jumpHosts))
+		})
+	}
+}
+
+func TestWriteSSHConfigIdentityFormat(t *testing.T) {
+	t.Parallel()
+
+	base := hostConfigParameters{
+		ClusterName:         "root.example.com",
+		KnownHostsPath:      "/home/user/.tsh/known_hosts",
+		IdentityFilePath:    "/home/user/.tsh/keys/proxy/user",
+		CertificateFilePath: "/home/user/.tsh/keys/proxy/user-ssh/root.example.com-cert.pub",
+		ProxyHost:           "proxy.example.com",
+		ProxyPort:           "3023",
+		TSHPath:             "/usr/local/bin/tsh",
+	}
+
+	tests := []struct {
+		name     string
+		params   hostConfigParameters
+		contains []string
+		excludes []string
+	}{
+		{
+			name: "file format emits IdentityFile and CertificateFile",
+			params: func() hostConfigParameters {
+				p := base
+				p.IdentityFormat = identityFormatFile
+				return p
+			}(),
+			contains: []string{
+				`IdentityFile "/home/user/.tsh/keys/proxy/user"`,
+				`CertificateFile "/home/user/.tsh/keys/proxy/user-ssh/root.example.com-cert.pub"`,
+			},
+			excludes: []string{"IdentityAgent", "PKCS11Provider"},
+		},
+		{
+			name: "agent format emits IdentityAgent only",
+			params: func() hostConfigParameters {
+				p := base
+				p.IdentityFormat = identityFormatAgent
+				p.AgentSocketPath = "SSH_AUTH_SOCK"
+				return p
+			}(),
+			contains: []string{"IdentityAgent SSH_AUTH_SOCK"},
+			excludes: []string{"IdentityFile", "CertificateFile", "PKCS11Provider"},
+		},
+		{
+			name: "pkcs11 format emits PKCS11Provider and CertificateFile",
+			params: func() hostConfigParameters {
+				p := base
+				p.IdentityFormat = identityFormatPKCS11
+				p.PKCS11ModulePath = "/usr/local/lib/libykcs11.dylib"
+				return p
+			}(),
+			contains: []string{
+				`PKCS11Provider "/usr/local/lib/libykcs11.dylib"`,
+				`CertificateFile "/home/user/.tsh/keys/proxy/user-ssh/root.example.com-cert.pub"`,
+			},
+			excludes: []string{"IdentityFile", "IdentityAgent"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sb strings.Builder
+			require.NoError(t, writeSSHConfig(&sb, tt.params))
+
+			for _, want := range tt.contains {
+				require.Contains(t, sb.String(), want)
+			}
+			for _, notWant := range tt.excludes {
+				require.NotContains(t, sb.String(), notWant)
+			}
+		})
+	}
+}
+
+func TestWriteSSHConfigProxyJump(t *testing.T) {
+	t.Parallel()
+
+	base := hostConfigParameters{
+		ClusterName:         "root.example.com",
+		KnownHostsPath:      "/home/user/.tsh/known_hosts",
+		IdentityFilePath:    "/home/user/.tsh/keys/proxy/user",
+		CertificateFilePath: "/home/user/.tsh/keys/proxy/user-ssh/root.example.com-cert.pub",
+		ProxyHost:           "proxy.example.com",
+		ProxyPort:           "3023",
+		TSHPath:             "/usr/local/bin/tsh",
+		IdentityFormat:      identityFormatFile,
+	}
+
+	t.Run("without jump hosts, config-proxy is invoked without --jumphost", func(t *testing.T) {
+		var sb strings.Builder
+		require.NoError(t, writeSSHConfig(&sb, base))
+		require.NotContains(t, sb.String(), "--jumphost")
+	})
+
+	t.Run("with jump hosts, both the ProxyJump line and config-proxy get the chain", func(t *testing.T) {
+		p := base
+		p.ProxyJump = "alice@bastion:22"
+
+		var sb strings.Builder
+		require.NoError(t, writeSSHConfig(&sb, p))
+		require.Contains(t, sb.String(), "ProxyJump alice@bastion:22")
+		require.Contains(t, sb.String(), "config-proxy --proxy=proxy.example.com:3023 --jumphost=alice@bastion:22 %h:%p")
+	})
+}
+
+func TestResolvePKCS11ModulePath(t *testing.T) {
+	t.Parallel()
+
+	t.Run("override takes precedence over the platform default", func(t *testing.T) {
+		path, err := resolvePKCS11ModulePath("/opt/nitrokey/libnitrokey-pkcs11.so")
+		require.NoError(t, err)
+		require.Equal(t, "/opt/nitrokey/libnitrokey-pkcs11.so", path)
+	})
+
+	t.Run("falls back to the platform default when unset", func(t *testing.T) {
+		path, err := resolvePKCS11ModulePath("")
+		if err != nil {
+			// Unsupported platforms (e.g. Windows) are expected to error;
+			// anything else is a real failure.
+			require.ErrorContains(t, err, "not supported")
+			return
+		}
+		defaultPath, defaultErr := getDefaultPKCS11ModulePath()
+		require.NoError(t, defaultErr)
+		require.Equal(t, defaultPath, path)
+	})
+}
+
+func TestFormatCertAuthorityLines(t *testing.T) {
+	t.Parallel()
+
+	hostCA, err := types.NewCertAuthority(types.CertAuthoritySpecV2{
+		Type:        types.HostCA,
+		ClusterName: "root.example.com",
+		CheckingKeys: [][]byte{
+			[]byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQC1 host-ca-key"),
+		},
+	})
+	require.NoError(t, err)
+
+	userCA, err := types.NewCertAuthority(types.CertAuthoritySpecV2{
+		Type:        types.UserCA,
+		ClusterName: "root.example.com",
+		CheckingKeys: [][]byte{
+			[]byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQC2 user-ca-key"),
+		},
+	})
+	require.NoError(t, err)
+
+	otherClusterHostCA, err := types.NewCertAuthority(types.CertAuthoritySpecV2{
+		Type:        types.HostCA,
+		ClusterName: "other.example.com",
+		CheckingKeys: [][]byte{
+			[]byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQC3 other-cluster-host-ca-key"),
+		},
+	})
+	require.NoError(t, err)
+
+	// Only the matching-cluster host CA should be emitted, as a single line
+	// matching both the cluster wildcard and the extra (proxy) host pattern.
+	// The user CA and the other cluster's host CA (both of which a root
+	// auth server may also return as trusted) must be filtered out.
+	lines := formatCertAuthorityLines("root.example.com", []types.CertAuthority{hostCA, userCA, otherClusterHostCA}, "proxy.example.com")
+	require.Equal(t, []string{
+		"@cert-authority *.root.example.com,proxy.example.com ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQC1 host-ca-key",
+	}, lines)
+
+	require.Empty(t, formatCertAuthorityLines("root.example.com", []types.CertAuthority{userCA, otherClusterHostCA}))
+}
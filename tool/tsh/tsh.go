@@ -0,0 +1,47 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// registerConfigFlags binds the `tsh config` subcommand's own flags to cf.
+// It is called when config is added to the rest of tsh's command tree.
+func registerConfigFlags(config *kingpin.CmdClause, cf *CLIConf) {
+	config.Flag("jumphost", "SSH jump host").Short('J').StringVar(&cf.JumpHost)
+	config.Flag("identity-format", fmt.Sprintf("Identity format to emit: %s, %s, or %s",
+		identityFormatFile, identityFormatAgent, identityFormatPKCS11)).
+		Default(identityFormatFile).
+		StringVar(&cf.IdentityFormat)
+	config.Flag("pkcs11-module-path", "Path to a PKCS#11 provider module, used with --identity-format=pkcs11 "+
+		"(defaults to a platform-specific YubiKey path if not set)").
+		StringVar(&cf.PKCS11ModulePath)
+	config.Flag("format", fmt.Sprintf("Configuration format, e.g. %s to emit CA-signed known_hosts entries instead of per-host ones",
+		configFormatCertAuthority)).
+		StringVar(&cf.Format)
+}
+
+// registerConfigProxyFlags binds the `tsh config-proxy` subcommand's own
+// flags to cf. config-proxy is invoked as the ProxyCommand for real node
+// hostnames (see sshConfigTemplate), so it needs its own --jumphost to reach
+// the proxy through the same bastion chain `tsh config` was generated with.
+func registerConfigProxyFlags(configProxy *kingpin.CmdClause, cf *CLIConf) {
+	configProxy.Flag("jumphost", "SSH jump host").Short('J').StringVar(&cf.JumpHost)
+}